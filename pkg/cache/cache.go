@@ -0,0 +1,277 @@
+// Package cache implements an in-memory, TTL-aware cache of DNS responses,
+// keyed by question name, type and class.
+package cache
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// shardCount controls lock contention: each shard owns its own mutex
+	// and LRU list, so concurrent Get/Set on different keys rarely block
+	// each other.
+	shardCount = 16
+	// maxNegativeTTL caps how long an NXDOMAIN/NODATA answer is cached
+	// for, regardless of what the upstream's SOA MINIMUM says, per the
+	// guidance in RFC 2308 section 5.
+	maxNegativeTTL = 5 * time.Minute
+	// evictInterval is how often the background goroutine sweeps expired
+	// entries out of every shard.
+	evictInterval = 30 * time.Second
+)
+
+type key struct {
+	name  string
+	qtype dnsmessage.Type
+	class dnsmessage.Class
+}
+
+func keyFromQuestion(question dnsmessage.Question) key {
+	return key{
+		name:  question.Name.String(),
+		qtype: question.Type,
+		class: question.Class,
+	}
+}
+
+type entry struct {
+	key        key
+	message    *dnsmessage.Message
+	insertedAt time.Time
+	expiresAt  time.Time
+}
+
+type shard struct {
+	mu      sync.Mutex
+	list    *list.List
+	entries map[key]*list.Element
+}
+
+func newShard() *shard {
+	return &shard{
+		list:    list.New(),
+		entries: make(map[key]*list.Element),
+	}
+}
+
+// Cache is a sharded LRU cache of DNS responses. It is safe for concurrent
+// use.
+type Cache struct {
+	shards             [shardCount]*shard
+	maxEntriesPerShard int
+}
+
+// New returns a Cache whose limit of maxEntries responses is evenly split
+// across its shards (maxEntries / shardCount each, but never less than
+// one per shard, so a limit below shardCount still allows up to
+// shardCount entries), and starts its background eviction goroutine.
+// maxEntries <= 0 means unbounded.
+func New(maxEntries int) *Cache {
+	c := &Cache{}
+
+	if maxEntries > 0 {
+		c.maxEntriesPerShard = maxEntries / shardCount
+		if c.maxEntriesPerShard < 1 {
+			c.maxEntriesPerShard = 1
+		}
+	}
+
+	for i := range c.shards {
+		c.shards[i] = newShard()
+	}
+
+	go c.evictExpiredForever()
+
+	return c
+}
+
+func (c *Cache) shardFor(k key) *shard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(k.name))
+
+	return c.shards[h.Sum32()%shardCount]
+}
+
+// Get returns the cached response to question with its answers' TTLs
+// decremented by the time spent in the cache, or nil on a miss or expiry.
+// The caller is responsible for rewriting the transaction ID to match the
+// incoming query.
+func (c *Cache) Get(question dnsmessage.Question) *dnsmessage.Message {
+	k := keyFromQuestion(question)
+	s := c.shardFor(k)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[k]
+	if !ok {
+		return nil
+	}
+
+	e := elem.Value.(*entry)
+
+	now := time.Now()
+	if now.After(e.expiresAt) {
+		s.list.Remove(elem)
+		delete(s.entries, k)
+
+		return nil
+	}
+
+	s.list.MoveToFront(elem)
+
+	return age(e.message, now.Sub(e.insertedAt))
+}
+
+// Set stores msg's answer to its own question, if it's cacheable. Positive
+// answers are kept for the lowest TTL among their records; NXDOMAIN/NODATA
+// answers are negatively cached per RFC 2308 using the SOA MINIMUM field,
+// capped at maxNegativeTTL. Anything else (SERVFAIL, REFUSED, ...) is not
+// cached.
+func (c *Cache) Set(msg *dnsmessage.Message) {
+	if len(msg.Questions) == 0 {
+		return
+	}
+
+	ttl, ok := ttlOf(msg)
+	if !ok || ttl <= 0 {
+		return
+	}
+
+	k := keyFromQuestion(msg.Questions[0])
+	s := c.shardFor(k)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[k]; ok {
+		s.list.Remove(elem)
+		delete(s.entries, k)
+	}
+
+	elem := s.list.PushFront(&entry{
+		key:        k,
+		message:    msg,
+		insertedAt: now,
+		expiresAt:  now.Add(ttl),
+	})
+	s.entries[k] = elem
+
+	if c.maxEntriesPerShard > 0 && s.list.Len() > c.maxEntriesPerShard {
+		oldest := s.list.Back()
+		s.list.Remove(oldest)
+		delete(s.entries, oldest.Value.(*entry).key)
+	}
+}
+
+// ttlOf determines how long msg should be cached for, and whether it
+// should be cached at all.
+func ttlOf(msg *dnsmessage.Message) (time.Duration, bool) {
+	if msg.RCode == dnsmessage.RCodeSuccess && len(msg.Answers) > 0 {
+		minTTL := msg.Answers[0].Header.TTL
+
+		for _, answer := range msg.Answers[1:] {
+			if answer.Header.TTL < minTTL {
+				minTTL = answer.Header.TTL
+			}
+		}
+
+		return time.Duration(minTTL) * time.Second, true
+	}
+
+	if msg.RCode != dnsmessage.RCodeNameError && msg.RCode != dnsmessage.RCodeSuccess {
+		return 0, false
+	}
+
+	for _, authority := range msg.Authorities {
+		soa, ok := authority.Body.(*dnsmessage.SOAResource)
+		if !ok {
+			continue
+		}
+
+		ttl := time.Duration(soa.MinTTL) * time.Second
+		if ttl > maxNegativeTTL {
+			ttl = maxNegativeTTL
+		}
+
+		return ttl, true
+	}
+
+	return 0, false
+}
+
+// age returns a copy of msg with every record's TTL, across the answer,
+// authority and additional sections, reduced by elapsed and floored at
+// zero. Aging the authority section matters for negatively cached
+// answers, whose TTL lives in the authority SOA's MINIMUM rather than in
+// Answers.
+func age(msg *dnsmessage.Message, elapsed time.Duration) *dnsmessage.Message {
+	aged := *msg
+	aged.Answers = ageResources(msg.Answers, elapsed)
+	aged.Authorities = ageResources(msg.Authorities, elapsed)
+	aged.Additionals = ageResources(msg.Additionals, elapsed)
+
+	return &aged
+}
+
+// ageResources returns a copy of resources with every TTL reduced by
+// elapsed, floored at zero. OPT pseudo-records are left untouched: their
+// "TTL" field is repurposed by EDNS0 to carry the extended RCODE and
+// flags, not an actual TTL.
+func ageResources(resources []dnsmessage.Resource, elapsed time.Duration) []dnsmessage.Resource {
+	aged := make([]dnsmessage.Resource, len(resources))
+	elapsedSeconds := uint32(elapsed / time.Second)
+
+	for i, resource := range resources {
+		aged[i] = resource
+
+		if resource.Header.Type == dnsmessage.TypeOPT {
+			continue
+		}
+
+		if resource.Header.TTL > elapsedSeconds {
+			aged[i].Header.TTL = resource.Header.TTL - elapsedSeconds
+		} else {
+			aged[i].Header.TTL = 0
+		}
+	}
+
+	return aged
+}
+
+func (c *Cache) evictExpiredForever() {
+	ticker := time.NewTicker(evictInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.evictExpired()
+	}
+}
+
+func (c *Cache) evictExpired() {
+	now := time.Now()
+
+	for _, s := range c.shards {
+		s.mu.Lock()
+
+		for elem := s.list.Back(); elem != nil; {
+			e := elem.Value.(*entry)
+			prev := elem.Prev()
+
+			if now.After(e.expiresAt) {
+				s.list.Remove(elem)
+				delete(s.entries, e.key)
+			}
+
+			elem = prev
+		}
+
+		s.mu.Unlock()
+	}
+}
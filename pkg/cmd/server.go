@@ -2,8 +2,10 @@ package cmd
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/adnsio/dotd/pkg/server"
+	"github.com/adnsio/dotd/pkg/strategy"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -24,8 +26,16 @@ var serverCmd = &cobra.Command{
 func init() {
 	serverCmd.Flags().StringP("address", "a", "[::1]:53", "listening address")
 	serverCmd.Flags().StringSliceP("upstreams", "u", []string{"https://1.1.1.1/dns-query", "https://1.0.0.1/dns-query"}, "upstream addresses")
+	serverCmd.Flags().String("upstream-strategy", string(strategy.RoundRobin), "upstream selection strategy (round-robin, parallel, fastest)")
 	serverCmd.Flags().StringSlice("blocklist", []string{}, "blocked domains")
 	serverCmd.Flags().StringToString("resolve", map[string]string{}, "custom resolve list")
+	serverCmd.Flags().Int("cache-size", 10000, "max number of cached responses, 0 to disable the limit")
+	serverCmd.Flags().String("querylog-jsonl", "", "path to a rotating JSONL query log file, empty to disable")
+	serverCmd.Flags().String("querylog-sqlite", "", "path to a SQLite query log database, empty to disable")
+	serverCmd.Flags().String("querylog-api-address", "", "listening address for the /api/stats and /api/log endpoints, empty to disable")
+	serverCmd.Flags().StringSlice("blocklist-sources", []string{}, "URLs or local file paths of hosts/adblock-style blocklists to ingest")
+	serverCmd.Flags().Duration("blocklist-refresh-interval", 24*time.Hour, "how often to re-download blocklist-sources, 0 to only load them once at startup")
+	serverCmd.Flags().String("blocklist-cache-dir", "", "directory to persist downloaded blocklist-sources in, empty to disable caching")
 
 	if err := viper.BindPFlags(serverCmd.Flags()); err != nil {
 		log.Fatal().Err(fmt.Errorf("viper: %w", err)).Send()
@@ -35,16 +45,36 @@ func init() {
 func runServer(_ *cobra.Command, _ []string) {
 	address := viper.GetString("address")
 	upstreams := viper.GetStringSlice("upstreams")
+	upstreamStrategy := viper.GetString("upstream-strategy")
+	routes := viper.GetStringMapStringSlice("routes")
 	blocklist := viper.GetStringSlice("blocklist")
 	blockregex := viper.GetStringSlice("blockregex")
 	resolve := viper.GetStringMapString("resolve")
+	cacheSize := viper.GetInt("cache-size")
+
+	queryLogConfig := server.QueryLogConfig{
+		JSONLPath:  viper.GetString("querylog-jsonl"),
+		SQLitePath: viper.GetString("querylog-sqlite"),
+		APIAddress: viper.GetString("querylog-api-address"),
+	}
+
+	blocklistConfig := server.BlocklistConfig{
+		Sources:         viper.GetStringSlice("blocklist-sources"),
+		RefreshInterval: viper.GetDuration("blocklist-refresh-interval"),
+		CacheDir:        viper.GetString("blocklist-cache-dir"),
+	}
 
 	server, err := server.New(
 		address,
 		upstreams,
+		strategy.Name(upstreamStrategy),
+		routes,
 		blocklist,
 		blockregex,
 		resolve,
+		cacheSize,
+		queryLogConfig,
+		blocklistConfig,
 	)
 	if err != nil {
 		log.Fatal().Err(fmt.Errorf("dotd: %w", err)).Send()
@@ -0,0 +1,255 @@
+// Package lists ingests domain blocklists from remote URLs and local
+// files, in hosts(5) and adblock-style domain syntax, merging them into a
+// single set that's refreshed on a timer.
+package lists
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // used only to derive a stable cache filename, not for security
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Manager owns the merged domain set parsed from one or more blocklist
+// sources and keeps it fresh in the background. It is safe for concurrent
+// use.
+type Manager struct {
+	sources  []string
+	interval time.Duration
+	cacheDir string
+
+	httpClient *http.Client
+
+	mu      sync.RWMutex
+	domains map[string]bool
+
+	metaMu sync.Mutex
+	meta   map[string]sourceMeta
+
+	// lastGoodMu guards lastGood, the last successfully-parsed domain set
+	// per source. It's consulted on a 304 or a failed refresh so an
+	// unchanged or momentarily-unreachable source doesn't fall out of the
+	// merged set, regardless of whether a cacheDir is configured.
+	lastGoodMu sync.Mutex
+	lastGood   map[string]map[string]bool
+}
+
+// sourceMeta tracks the conditional-GET headers for a source, so an
+// unchanged remote list isn't re-downloaded on every refresh.
+type sourceMeta struct {
+	etag         string
+	lastModified string
+}
+
+// New returns a Manager for the given sources (each a URL or a local file
+// path). interval <= 0 disables the background refresh, leaving only the
+// initial load done by Start. cacheDir, if non-empty, is where downloaded
+// copies are persisted so a restart can load them without a network round
+// trip.
+func New(sources []string, interval time.Duration, cacheDir string) *Manager {
+	return &Manager{
+		sources:  sources,
+		interval: interval,
+		cacheDir: cacheDir,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		domains:  make(map[string]bool),
+		meta:     make(map[string]sourceMeta),
+		lastGood: make(map[string]map[string]bool),
+	}
+}
+
+// Start loads every source once and, if an interval was configured, keeps
+// refreshing them in the background.
+func (m *Manager) Start() error {
+	if m.cacheDir != "" {
+		if err := os.MkdirAll(m.cacheDir, 0o755); err != nil {
+			return fmt.Errorf("os: %w", err)
+		}
+	}
+
+	if err := m.refreshAll(); err != nil {
+		return err
+	}
+
+	if m.interval > 0 {
+		go m.refreshForever()
+	}
+
+	return nil
+}
+
+func (m *Manager) refreshForever() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := m.refreshAll(); err != nil {
+			log.Err(fmt.Errorf("lists: %w", err)).Send()
+		}
+	}
+}
+
+// refreshAll re-fetches every source and atomically swaps in the merged
+// result, so Contains never blocks on a slow download.
+func (m *Manager) refreshAll() error {
+	merged := make(map[string]bool)
+
+	for _, source := range m.sources {
+		domains, err := m.fetch(source)
+		if err != nil {
+			return fmt.Errorf(`"%s": %w`, source, err)
+		}
+
+		for domain := range domains {
+			merged[domain] = true
+		}
+	}
+
+	m.mu.Lock()
+	m.domains = merged
+	m.mu.Unlock()
+
+	log.Info().Int("domains", len(merged)).Msg("lists: refreshed blocklists")
+
+	return nil
+}
+
+// Contains reports whether domain is present in the most recently loaded
+// set of sources.
+func (m *Manager) Contains(domain string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.domains[domain]
+}
+
+func (m *Manager) fetch(source string) (map[string]bool, error) {
+	if isRemote(source) {
+		return m.fetchRemote(source)
+	}
+
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("os: %w", err)
+	}
+	defer file.Close()
+
+	return parse(file), nil
+}
+
+func isRemote(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+func (m *Manager) fetchRemote(source string) (map[string]bool, error) {
+	req, err := http.NewRequest(http.MethodGet, source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http: %w", err)
+	}
+
+	m.metaMu.Lock()
+	meta := m.meta[source]
+	m.metaMu.Unlock()
+
+	if meta.etag != "" {
+		req.Header.Set("if-none-match", meta.etag)
+	}
+
+	if meta.lastModified != "" {
+		req.Header.Set("if-modified-since", meta.lastModified)
+	}
+
+	res, err := m.httpClient.Do(req)
+	if err != nil {
+		return m.fetchCached(source, fmt.Errorf("http: %w", err))
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified {
+		return m.fetchCached(source, nil)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return m.fetchCached(source, fmt.Errorf(`http: unexpected status "%d"`, res.StatusCode))
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("io: %w", err)
+	}
+
+	if m.cacheDir != "" {
+		if err := ioutil.WriteFile(m.cachePath(source), data, 0o644); err != nil {
+			log.Err(fmt.Errorf("lists: %w", err)).Send()
+		}
+	}
+
+	m.metaMu.Lock()
+	m.meta[source] = sourceMeta{
+		etag:         res.Header.Get("etag"),
+		lastModified: res.Header.Get("last-modified"),
+	}
+	m.metaMu.Unlock()
+
+	domains := parse(bytes.NewReader(data))
+
+	m.lastGoodMu.Lock()
+	m.lastGood[source] = domains
+	m.lastGoodMu.Unlock()
+
+	return domains, nil
+}
+
+// fetchCached falls back to source's last successfully-parsed domain set,
+// so a 304, a network hiccup, or an upstream error doesn't empty out the
+// blocklist. It tries the in-memory copy from a previous successful fetch
+// first, then the on-disk cache if one is configured. cause is returned
+// as-is when there's nothing to fall back to.
+func (m *Manager) fetchCached(source string, cause error) (map[string]bool, error) {
+	m.lastGoodMu.Lock()
+	domains, ok := m.lastGood[source]
+	m.lastGoodMu.Unlock()
+
+	if ok {
+		return domains, nil
+	}
+
+	if m.cacheDir == "" {
+		return nil, cause
+	}
+
+	data, err := ioutil.ReadFile(m.cachePath(source))
+	if err != nil {
+		if cause != nil {
+			return nil, cause
+		}
+
+		return nil, fmt.Errorf("os: %w", err)
+	}
+
+	log.Warn().Msgf(`lists: using cached copy of "%s"`, source)
+
+	domains = parse(bytes.NewReader(data))
+
+	m.lastGoodMu.Lock()
+	m.lastGood[source] = domains
+	m.lastGoodMu.Unlock()
+
+	return domains, nil
+}
+
+func (m *Manager) cachePath(source string) string {
+	digest := sha1.Sum([]byte(source))
+
+	return filepath.Join(m.cacheDir, fmt.Sprintf("%x.list", digest))
+}
@@ -0,0 +1,120 @@
+package lists
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// domainLabelPattern matches a single valid DNS label, per RFC 1035
+// section 2.3.1.
+var domainLabelPattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// hostsAddresses are the "blackhole" addresses hosts(5)-style blocklists
+// (e.g. Steven Black's) point blocked domains at.
+var hostsAddresses = map[string]bool{
+	"0.0.0.0":   true,
+	"127.0.0.1": true,
+	"::1":       true,
+	"::":        true,
+}
+
+// parse reads domains out of r, accepting hosts(5) lines ("0.0.0.0
+// domain"), adblock/AdGuard domain rules ("||domain^"), and plain
+// one-domain-per-line lists (EasyList domain-only style), skipping blank
+// lines and "#"/"!" comments.
+func parse(r io.Reader) map[string]bool {
+	domains := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+
+		if domain, ok := adblockDomain(line); ok {
+			domains[domain] = true
+
+			continue
+		}
+
+		domain, ok := hostsDomain(line)
+		if !ok {
+			continue
+		}
+
+		domains[domain] = true
+	}
+
+	return domains
+}
+
+// adblockDomain extracts the domain out of an adblock/AdGuard domain rule
+// like "||ads.example.com^" or "||ads.example.com^$third-party". It
+// reports false for any other adblock syntax (element hiding, regex,
+// exceptions, ...), which this simple blocklist ingestion doesn't support.
+func adblockDomain(line string) (string, bool) {
+	if !strings.HasPrefix(line, "||") {
+		return "", false
+	}
+
+	rule := strings.TrimPrefix(line, "||")
+
+	if idx := strings.IndexAny(rule, "^$/"); idx != -1 {
+		rule = rule[:idx]
+	}
+
+	if rule == "" || !isPlausibleDomain(rule) {
+		return "", false
+	}
+
+	return rule, true
+}
+
+// hostsDomain extracts the domain out of either a bare "domain.tld" line
+// or a hosts(5) "<address> domain.tld [# comment]" line.
+func hostsDomain(line string) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", false
+	}
+
+	domain := fields[0]
+
+	if len(fields) > 1 && (hostsAddresses[fields[0]] || net.ParseIP(fields[0]) != nil) {
+		domain = fields[1]
+	}
+
+	domain = strings.TrimSuffix(domain, ".")
+
+	if domain == "" || domain == "localhost" || !isPlausibleDomain(domain) {
+		return "", false
+	}
+
+	return domain, true
+}
+
+// isPlausibleDomain reports whether domain looks like a real DNS name.
+// It guards against adblock syntax that isn't a "||domain^" rule — allowlist
+// exceptions ("@@||domain^"), element-hiding rules ("domain##.ad"), and
+// option modifiers ("domain$third-party") — being swallowed whole as a
+// bogus domain by hostsDomain.
+func isPlausibleDomain(domain string) bool {
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+
+	for _, label := range labels {
+		if !domainLabelPattern.MatchString(label) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,47 @@
+package querylog
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// NewAPIHandler returns a read-only HTTP handler exposing aggregated stats
+// at GET /api/stats, and, when sqliteSink is non-nil, raw entries at
+// GET /api/log?client=....
+func NewAPIHandler(logger *Logger, sqliteSink *SQLiteSink) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/stats", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"clients": logger.Stats().ByClient(),
+			"domains": logger.Stats().ByDomain(),
+		})
+	})
+
+	mux.HandleFunc("/api/log", func(w http.ResponseWriter, r *http.Request) {
+		if sqliteSink == nil {
+			http.Error(w, "query log has no sqlite sink configured", http.StatusNotImplemented)
+
+			return
+		}
+
+		entries, err := sqliteSink.Query(r.URL.Query().Get("client"), 100)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		writeJSON(w, entries)
+	})
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("content-type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,102 @@
+package querylog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxJSONLFileSize is the size, in bytes, at which a JSONLSink rotates its
+// current file to a timestamped name and starts a new one.
+const maxJSONLFileSize = 50 * 1024 * 1024 // 50MiB
+
+// JSONLSink appends each Entry as a line of JSON to a file, rotating it
+// once it grows past maxJSONLFileSize.
+type JSONLSink struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int64
+}
+
+// NewJSONLSink opens (or creates) the JSONL file at path, appending to it.
+func NewJSONLSink(path string) (*JSONLSink, error) {
+	sink := &JSONLSink{path: path}
+
+	if err := sink.openCurrent(); err != nil {
+		return nil, err
+	}
+
+	return sink, nil
+}
+
+func (s *JSONLSink) openCurrent() error {
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("os: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("os: %w", err)
+	}
+
+	s.file = file
+	s.size = info.Size()
+
+	return nil
+}
+
+func (s *JSONLSink) Write(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("json: %w", err)
+	}
+
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size+int64(len(data)) > maxJSONLFileSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(data)
+	if err != nil {
+		return fmt.Errorf("os: %w", err)
+	}
+
+	s.size += int64(n)
+
+	return nil
+}
+
+func (s *JSONLSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("os: %w", err)
+	}
+
+	rotatedPath := fmt.Sprintf("%s.%s", s.path, time.Now().Format("20060102150405"))
+
+	if err := os.Rename(s.path, rotatedPath); err != nil {
+		return fmt.Errorf("os: %w", err)
+	}
+
+	return s.openCurrent()
+}
+
+func (s *JSONLSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("os: %w", err)
+	}
+
+	return nil
+}
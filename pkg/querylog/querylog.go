@@ -0,0 +1,107 @@
+// Package querylog records every DNS question dotd answers, and exposes
+// aggregated per-client and per-domain counters over a read-only HTTP API.
+package querylog
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// entryQueueSize bounds how many entries may be buffered waiting to be
+// written to a sink; once full, new entries are dropped so a slow sink
+// never stalls DNS resolution.
+const entryQueueSize = 1024
+
+// Path identifies which stage of the resolution pipeline answered a query.
+type Path string
+
+const (
+	PathResolve    Path = "resolve"
+	PathBlocklist  Path = "blocklist"
+	PathBlockregex Path = "blockregex"
+	PathCache      Path = "cache"
+	PathUpstream   Path = "upstream"
+)
+
+// Entry records everything about a single answered DNS question.
+type Entry struct {
+	Time     time.Time     `json:"time"`
+	ClientIP string        `json:"client_ip"`
+	QName    string        `json:"qname"`
+	QType    string        `json:"qtype"`
+	Path     Path          `json:"path"`
+	Upstream string        `json:"upstream,omitempty"`
+	Latency  time.Duration `json:"latency"`
+	RCode    string        `json:"rcode"`
+	Answers  []string      `json:"answers,omitempty"`
+}
+
+// Sink persists query log entries somewhere: a file, a database, ...
+type Sink interface {
+	Write(entry Entry) error
+	Close() error
+}
+
+// Logger fans logged entries out to every configured Sink and keeps the
+// aggregated Stats used by the HTTP API, without ever blocking the caller
+// that answered the query.
+type Logger struct {
+	sinks   []Sink
+	entries chan Entry
+	stats   *Stats
+}
+
+// New returns a Logger writing to sinks and starts its background worker.
+func New(sinks ...Sink) *Logger {
+	l := &Logger{
+		sinks:   sinks,
+		entries: make(chan Entry, entryQueueSize),
+		stats:   newStats(),
+	}
+
+	go l.run()
+
+	return l
+}
+
+func (l *Logger) run() {
+	for entry := range l.entries {
+		l.stats.record(entry)
+
+		for _, sink := range l.sinks {
+			if err := sink.Write(entry); err != nil {
+				log.Err(fmt.Errorf("querylog: %w", err)).Send()
+			}
+		}
+	}
+}
+
+// Log enqueues entry without blocking. If the queue is full the entry is
+// dropped and a warning is logged.
+func (l *Logger) Log(entry Entry) {
+	select {
+	case l.entries <- entry:
+	default:
+		log.Warn().Msg("querylog: queue is full, dropping entry")
+	}
+}
+
+// Stats returns the Logger's aggregated per-client and per-domain counters.
+func (l *Logger) Stats() *Stats {
+	return l.stats
+}
+
+// Close stops accepting new entries and closes every sink.
+func (l *Logger) Close() error {
+	close(l.entries)
+
+	for _, sink := range l.sinks {
+		if err := sink.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package querylog
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	// registers the "sqlite3" database/sql driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteSink persists entries to an embedded SQLite database, and backs the
+// /api/log endpoint's ability to query past entries.
+type SQLiteSink struct {
+	db *sql.DB
+}
+
+// NewSQLiteSink opens (or creates) the SQLite database at path and ensures
+// its schema exists.
+func NewSQLiteSink(path string) (*SQLiteSink, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("sql: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS entries (
+			time      DATETIME NOT NULL,
+			client_ip TEXT NOT NULL,
+			qname     TEXT NOT NULL,
+			qtype     TEXT NOT NULL,
+			path      TEXT NOT NULL,
+			upstream  TEXT,
+			latency   INTEGER NOT NULL,
+			rcode     TEXT NOT NULL,
+			answers   TEXT
+		)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("sql: %w", err)
+	}
+
+	return &SQLiteSink{db: db}, nil
+}
+
+func (s *SQLiteSink) Write(entry Entry) error {
+	_, err := s.db.Exec(
+		`INSERT INTO entries (time, client_ip, qname, qtype, path, upstream, latency, rcode, answers)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Time, entry.ClientIP, entry.QName, entry.QType, entry.Path, entry.Upstream,
+		entry.Latency.Milliseconds(), entry.RCode, strings.Join(entry.Answers, ","),
+	)
+	if err != nil {
+		return fmt.Errorf("sql: %w", err)
+	}
+
+	return nil
+}
+
+// Query returns the most recent entries, filtered to clientIP when it's
+// non-empty.
+func (s *SQLiteSink) Query(clientIP string, limit int) ([]Entry, error) {
+	query := `SELECT time, client_ip, qname, qtype, path, upstream, latency, rcode, answers FROM entries`
+
+	args := make([]interface{}, 0, 2)
+
+	if clientIP != "" {
+		query += ` WHERE client_ip = ?`
+		args = append(args, clientIP)
+	}
+
+	query += ` ORDER BY time DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("sql: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+
+	for rows.Next() {
+		var (
+			entry     Entry
+			latencyMs int64
+			answers   string
+		)
+
+		if err := rows.Scan(
+			&entry.Time, &entry.ClientIP, &entry.QName, &entry.QType, &entry.Path,
+			&entry.Upstream, &latencyMs, &entry.RCode, &answers,
+		); err != nil {
+			return nil, fmt.Errorf("sql: %w", err)
+		}
+
+		entry.Latency = time.Duration(latencyMs) * time.Millisecond
+
+		if answers != "" {
+			entry.Answers = strings.Split(answers, ",")
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sql: %w", err)
+	}
+
+	return entries, nil
+}
+
+func (s *SQLiteSink) Close() error {
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("sql: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package querylog
+
+import "sync"
+
+// Counter holds a domain's or client's query totals.
+type Counter struct {
+	Queries int `json:"queries"`
+	Blocked int `json:"blocked"`
+}
+
+// Stats aggregates logged entries into per-client and per-domain counters.
+// It is safe for concurrent use.
+type Stats struct {
+	mu       sync.Mutex
+	byClient map[string]*Counter
+	byDomain map[string]*Counter
+}
+
+func newStats() *Stats {
+	return &Stats{
+		byClient: make(map[string]*Counter),
+		byDomain: make(map[string]*Counter),
+	}
+}
+
+func (s *Stats) record(entry Entry) {
+	blocked := entry.Path == PathBlocklist || entry.Path == PathBlockregex
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	increment(s.byClient, entry.ClientIP, blocked)
+	increment(s.byDomain, entry.QName, blocked)
+}
+
+func increment(counters map[string]*Counter, key string, blocked bool) {
+	counter, ok := counters[key]
+	if !ok {
+		counter = &Counter{}
+		counters[key] = counter
+	}
+
+	counter.Queries++
+
+	if blocked {
+		counter.Blocked++
+	}
+}
+
+// ByClient returns a snapshot of the per-client counters, keyed by IP.
+func (s *Stats) ByClient() map[string]Counter {
+	return s.snapshot(s.byClient)
+}
+
+// ByDomain returns a snapshot of the per-domain counters, keyed by qname.
+func (s *Stats) ByDomain() map[string]Counter {
+	return s.snapshot(s.byDomain)
+}
+
+func (s *Stats) snapshot(counters map[string]*Counter) map[string]Counter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(map[string]Counter, len(counters))
+
+	for key, counter := range counters {
+		result[key] = *counter
+	}
+
+	return result
+}
@@ -1,32 +1,171 @@
 package server
 
 import (
-	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/adnsio/dotd/pkg/cache"
+	"github.com/adnsio/dotd/pkg/lists"
+	"github.com/adnsio/dotd/pkg/querylog"
 	"github.com/adnsio/dotd/pkg/roundrobin"
+	"github.com/adnsio/dotd/pkg/strategy"
+	"github.com/adnsio/dotd/pkg/upstream"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/net/dns/dnsmessage"
 )
 
+const (
+	// defaultUDPPayloadSize is used for clients that don't advertise an
+	// EDNS0 buffer size (or don't send OPT at all), per RFC 6891 section 6.2.5.
+	defaultUDPPayloadSize = 512
+	// maxUDPPayloadSize caps whatever a client advertises so a single
+	// malicious OPT record can't make us attempt to write an oversized
+	// UDP datagram.
+	maxUDPPayloadSize = 4096
+	// tcpMessageLengthSize is the size in bytes of the length prefix used
+	// to frame DNS messages on a TCP stream, per RFC 1035 section 4.2.2.
+	tcpMessageLengthSize = 2
+	// queryTimeout bounds how long a single query, including any upstream
+	// exchange(s), is allowed to run before it's abandoned.
+	queryTimeout = 5 * time.Second
+)
+
 type Server struct {
 	udpAddress         *net.UDPAddr
+	tcpAddress         *net.TCPAddr
 	upstreamRoundRobin *roundrobin.RoundRobin
+	upstreamStrategy   strategy.Strategy
+	routes             []*route
 	udpConnection      *net.UDPConn
+	tcpListener        *net.TCPListener
 	blocklist          map[string]bool
 	blockRegex         []*regexp.Regexp
 	resolve            map[string]string
-	httpClient         *http.Client
+	upstreamPool       *upstream.Pool
+	cache              *cache.Cache
+	queryLog           *querylog.Logger
+	querySQLiteSink    *querylog.SQLiteSink
+	apiAddress         string
+	lists              *lists.Manager
+}
+
+// route forwards any query whose name matches suffix to its own upstream
+// pool, independently of the server's global round-robin.
+type route struct {
+	suffix     string
+	roundRobin *roundrobin.RoundRobin
+}
+
+// nameSuffixes returns every dot-separated suffix of name, most specific
+// first, e.g. "a.b.c" -> ["a.b.c", "b.c", "c"].
+func nameSuffixes(name string) []string {
+	labels := strings.Split(name, ".")
+	suffixes := make([]string, 0, len(labels))
+
+	for i := range labels {
+		suffixes = append(suffixes, strings.Join(labels[i:], "."))
+	}
+
+	return suffixes
+}
+
+// pickUpstreamRoundRobin returns the round-robin pool of the most-specific
+// route matching name, falling back to the server's global upstreams.
+func (s *Server) pickUpstreamRoundRobin(name string) *roundrobin.RoundRobin {
+	name = strings.ToLower(name)
+	suffixes := make(map[string]bool, strings.Count(name, ".")+1)
+
+	for _, suffix := range nameSuffixes(name) {
+		suffixes[suffix] = true
+	}
+
+	for _, r := range s.routes {
+		if suffixes[r.suffix] {
+			return r.roundRobin
+		}
+	}
+
+	return s.upstreamRoundRobin
+}
+
+// respWriter abstracts writing a DNS response back to whatever transport the
+// query arrived on, so answerDNSMessage doesn't need to know whether it's
+// talking to a UDP or a TCP client.
+type respWriter interface {
+	// maxSize returns the largest response, in bytes, the writer is
+	// allowed to send. For UDP this is the client's EDNS0 buffer size
+	// (or 512 without EDNS0); TCP has no practical limit.
+	maxSize() int
+	writeData(data []byte) error
+	// clientIP returns the querying client's address, for query logging.
+	clientIP() string
+}
+
+type udpRespWriter struct {
+	conn    *net.UDPConn
+	addr    *net.UDPAddr
+	bufSize int
+}
+
+func (w *udpRespWriter) maxSize() int {
+	return w.bufSize
+}
+
+func (w *udpRespWriter) writeData(data []byte) error {
+	if _, err := w.conn.WriteToUDP(data, w.addr); err != nil {
+		return fmt.Errorf("udp: %w", err)
+	}
+
+	return nil
+}
+
+func (w *udpRespWriter) clientIP() string {
+	return w.addr.IP.String()
+}
+
+type tcpRespWriter struct {
+	conn net.Conn
+}
+
+func (w *tcpRespWriter) maxSize() int {
+	// framed by a 2-byte length prefix, so the practical limit is 65535
+	return 65535
+}
+
+func (w *tcpRespWriter) writeData(data []byte) error {
+	length := make([]byte, tcpMessageLengthSize)
+	binary.BigEndian.PutUint16(length, uint16(len(data)))
+
+	if _, err := w.conn.Write(length); err != nil {
+		return fmt.Errorf("tcp: %w", err)
+	}
+
+	if _, err := w.conn.Write(data); err != nil {
+		return fmt.Errorf("tcp: %w", err)
+	}
+
+	return nil
+}
+
+func (w *tcpRespWriter) clientIP() string {
+	host, _, err := net.SplitHostPort(w.conn.RemoteAddr().String())
+	if err != nil {
+		return w.conn.RemoteAddr().String()
+	}
+
+	return host
 }
 
 func (s *Server) ListenAndServe() error {
@@ -38,7 +177,15 @@ func (s *Server) ListenAndServe() error {
 	}
 	defer s.udpConnection.Close()
 
-	log.Info().Msgf("listening on %s", s.udpAddress.String())
+	log.Info().Msgf("listening on %s (udp)", s.udpAddress.String())
+
+	s.tcpListener, err = net.ListenTCP("tcp", s.tcpAddress)
+	if err != nil {
+		return fmt.Errorf("tcp: %w", err)
+	}
+	defer s.tcpListener.Close()
+
+	log.Info().Msgf("listening on %s (tcp)", s.tcpAddress.String())
 
 	exit := make(chan bool)
 
@@ -47,13 +194,32 @@ func (s *Server) ListenAndServe() error {
 		go s.readFromUDP()
 	}
 
+	go s.acceptTCP()
+
+	if s.apiAddress != "" {
+		go s.serveAPI()
+	}
+
 	<-exit
 
 	return nil
 }
 
+// serveAPI runs the query log's read-only HTTP API until it fails; errors
+// are logged rather than returned, since the API is an optional add-on and
+// shouldn't take down DNS resolution.
+func (s *Server) serveAPI() {
+	log.Info().Msgf("listening on %s (api)", s.apiAddress)
+
+	handler := querylog.NewAPIHandler(s.queryLog, s.querySQLiteSink)
+
+	if err := http.ListenAndServe(s.apiAddress, handler); err != nil {
+		log.Err(fmt.Errorf("api: %w", err)).Send()
+	}
+}
+
 func (s *Server) readFromUDP() {
-	data := make([]byte, 1024)
+	data := make([]byte, maxUDPPayloadSize)
 
 	for {
 		dataLength, addr, err := s.udpConnection.ReadFromUDP(data)
@@ -64,19 +230,30 @@ func (s *Server) readFromUDP() {
 			continue
 		}
 
+		queryData := make([]byte, dataLength)
+		copy(queryData, data[:dataLength])
+
 		// launch a go routine to answer
 		go func() {
 			// unpack data as dns message
 			dnsMessage := &dnsmessage.Message{}
 
-			err := dnsMessage.Unpack(data[:dataLength])
-			if err != nil {
+			if err := dnsMessage.Unpack(queryData); err != nil {
 				log.Err(fmt.Errorf("dnsmessage: %w", err)).Send()
 
 				return
 			}
 
-			if err := s.answerDNSMessage(addr, dnsMessage, data[:dataLength]); err != nil {
+			w := &udpRespWriter{
+				conn:    s.udpConnection,
+				addr:    addr,
+				bufSize: requestedUDPPayloadSize(dnsMessage),
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+			defer cancel()
+
+			if err := s.answerDNSMessage(ctx, w, dnsMessage, queryData); err != nil {
 				log.Err(err).
 					Uint16("id", dnsMessage.ID).
 					Send()
@@ -85,14 +262,84 @@ func (s *Server) readFromUDP() {
 	}
 }
 
-func (s *Server) answerDNSMessage(addr *net.UDPAddr, dnsMessage *dnsmessage.Message, data []byte) error {
+func (s *Server) acceptTCP() {
+	for {
+		conn, err := s.tcpListener.AcceptTCP()
+		if err != nil {
+			log.Err(fmt.Errorf("tcp: %w", err)).Send()
+
+			continue
+		}
+
+		go s.readFromTCP(conn)
+	}
+}
+
+func (s *Server) readFromTCP(conn *net.TCPConn) {
+	defer conn.Close()
+
+	for {
+		length := make([]byte, tcpMessageLengthSize)
+
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return
+		}
+
+		queryData := make([]byte, binary.BigEndian.Uint16(length))
+
+		if _, err := io.ReadFull(conn, queryData); err != nil {
+			log.Err(fmt.Errorf("tcp: %w", err)).Send()
+
+			return
+		}
+
+		dnsMessage := &dnsmessage.Message{}
+
+		if err := dnsMessage.Unpack(queryData); err != nil {
+			log.Err(fmt.Errorf("dnsmessage: %w", err)).Send()
+
+			return
+		}
+
+		w := &tcpRespWriter{
+			conn: conn,
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+
+		err := s.answerDNSMessage(ctx, w, dnsMessage, queryData)
+		cancel()
+
+		if err != nil {
+			log.Err(err).
+				Uint16("id", dnsMessage.ID).
+				Send()
+
+			return
+		}
+	}
+}
+
+func (s *Server) answerDNSMessage(ctx context.Context, w respWriter, dnsMessage *dnsmessage.Message, data []byte) error {
+	if len(dnsMessage.Questions) == 0 {
+		return s.writeDNSMessage(w, &dnsmessage.Message{
+			Header: dnsmessage.Header{
+				ID:       dnsMessage.ID,
+				Response: true,
+				RCode:    dnsmessage.RCodeFormatError,
+			},
+		})
+	}
+
+	start := time.Now()
 	question := dnsMessage.Questions[0]
+	clientIP := w.clientIP()
 
 	log.Debug().
 		Uint16("id", dnsMessage.ID).
 		Str("name", question.Name.String()).
 		Str("type", question.Type.String()).
-		Msgf("dns question from %s", addr.String())
+		Msg("dns question received")
 
 	// try resolve
 	answeredDNSMessage, err := s.answerQuestionWithResolve(dnsMessage.ID, &question)
@@ -101,63 +348,136 @@ func (s *Server) answerDNSMessage(addr *net.UDPAddr, dnsMessage *dnsmessage.Mess
 	}
 
 	if answeredDNSMessage != nil {
-		if err := s.writeDNSMessageToUPD(answeredDNSMessage, addr); err != nil {
-			return err
-		}
+		s.logQuery(querylog.PathResolve, "", clientIP, &question, answeredDNSMessage, start)
 
-		return nil
+		return s.writeDNSMessage(w, answeredDNSMessage)
 	}
 
 	// try blocklist
 	answeredDNSMessage = s.answerQuestionWithBlocklist(dnsMessage.ID, &question)
 	if answeredDNSMessage != nil {
-		if err := s.writeDNSMessageToUPD(answeredDNSMessage, addr); err != nil {
-			return err
-		}
+		s.logQuery(querylog.PathBlocklist, "", clientIP, &question, answeredDNSMessage, start)
 
-		return nil
+		return s.writeDNSMessage(w, answeredDNSMessage)
 	}
 
 	// try blockregex
 	answeredDNSMessage = s.answerQuestionWithBlockregex(dnsMessage.ID, &question)
 	if answeredDNSMessage != nil {
-		if err := s.writeDNSMessageToUPD(answeredDNSMessage, addr); err != nil {
-			return err
-		}
+		s.logQuery(querylog.PathBlockregex, "", clientIP, &question, answeredDNSMessage, start)
 
-		return nil
+		return s.writeDNSMessage(w, answeredDNSMessage)
+	}
+
+	// try cache
+	answeredDNSMessage = s.cache.Get(question)
+	if answeredDNSMessage != nil {
+		answeredDNSMessage.ID = dnsMessage.ID
+
+		s.logQuery(querylog.PathCache, "", clientIP, &question, answeredDNSMessage, start)
+
+		return s.writeDNSMessage(w, answeredDNSMessage)
 	}
 
 	// forward to upstream
-	answerData, err := s.forwardDataToUpstream(dnsMessage.ID, data)
+	name := question.Name.Data[:question.Name.Length-1]
+
+	upstreamRoundRobin := s.pickUpstreamRoundRobin(string(name))
+
+	answerData, upstream, err := s.forwardDataToUpstream(ctx, dnsMessage.ID, upstreamRoundRobin, data)
 	if err != nil {
 		return err
 	}
 
-	if err := s.writeDataToUDP(dnsMessage.ID, answerData, addr); err != nil {
-		return err
+	s.cacheAndLogAnswer(dnsMessage.ID, clientIP, &question, upstream, answerData, start)
+
+	return s.writeData(w, dnsMessage.ID, answerData)
+}
+
+// cacheAndLogAnswer unpacks a forwarded upstream response so it can be
+// cached and logged; unpack failures are logged and otherwise ignored,
+// since the response is still written back to the client as-is.
+func (s *Server) cacheAndLogAnswer(id uint16, clientIP string, question *dnsmessage.Question, upstream *url.URL, data []byte, start time.Time) {
+	msg := &dnsmessage.Message{}
+
+	if err := msg.Unpack(data); err != nil {
+		log.Err(fmt.Errorf("dnsmessage: %w", err)).
+			Uint16("id", id).
+			Send()
+
+		return
 	}
 
-	return nil
+	s.cache.Set(msg)
+
+	upstreamURL := ""
+	if upstream != nil {
+		upstreamURL = upstream.String()
+	}
+
+	s.logQuery(querylog.PathUpstream, upstreamURL, clientIP, question, msg, start)
 }
 
-func (s *Server) writeDNSMessageToUPD(msg *dnsmessage.Message, addr *net.UDPAddr) error {
-	msgData, err := msg.Pack()
-	if err != nil {
-		return fmt.Errorf("dnsmessage: %w", err)
+// logQuery enqueues a querylog.Entry for the answered question, if query
+// logging is configured.
+func (s *Server) logQuery(path querylog.Path, upstream, clientIP string, question *dnsmessage.Question, answered *dnsmessage.Message, start time.Time) {
+	if s.queryLog == nil {
+		return
 	}
 
-	if err := s.writeDataToUDP(msg.ID, msgData, addr); err != nil {
-		return err
+	s.queryLog.Log(querylog.Entry{
+		Time:     start,
+		ClientIP: clientIP,
+		QName:    question.Name.String(),
+		QType:    question.Type.String(),
+		Path:     path,
+		Upstream: upstream,
+		Latency:  time.Since(start),
+		RCode:    answered.RCode.String(),
+		Answers:  answersOf(answered),
+	})
+}
+
+// answersOf extracts the A/AAAA records of msg as plain IP strings, for
+// query logging.
+func answersOf(msg *dnsmessage.Message) []string {
+	answers := make([]string, 0, len(msg.Answers))
+
+	for _, answer := range msg.Answers {
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			answers = append(answers, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			answers = append(answers, net.IP(body.AAAA[:]).String())
+		}
 	}
 
-	return nil
+	return answers
 }
 
-func (s *Server) writeDataToUDP(id uint16, data []byte, addr *net.UDPAddr) error {
-	_, err := s.udpConnection.WriteToUDP(data, addr)
+// writeDNSMessage packs msg and writes it through w, truncating (and setting
+// the TC bit) when it doesn't fit in w.maxSize(), per RFC 1035 section 4.1.1.
+func (s *Server) writeDNSMessage(w respWriter, msg *dnsmessage.Message) error {
+	msgData, err := msg.Pack()
 	if err != nil {
-		return fmt.Errorf("write: %w", err)
+		return fmt.Errorf("dnsmessage: %w", err)
+	}
+
+	return s.writeData(w, msg.ID, msgData)
+}
+
+func (s *Server) writeData(w respWriter, id uint16, data []byte) error {
+	if len(data) > w.maxSize() {
+		truncated, err := truncateDNSMessage(data)
+		if err != nil {
+			return err
+		}
+
+		data = truncated
+	}
+
+	if err := w.writeData(data); err != nil {
+		return err
 	}
 
 	log.Debug().
@@ -167,67 +487,98 @@ func (s *Server) writeDataToUDP(id uint16, data []byte, addr *net.UDPAddr) error
 	return nil
 }
 
-func (s *Server) forwardDataToUpstream(id uint16, data []byte) ([]byte, error) {
-	maxAttempts := s.upstreamRoundRobin.Length()
-
-	for i := 0; i < maxAttempts; i++ {
-		upstream, err := s.upstreamRoundRobin.Pick()
-		if err != nil {
-			return nil, fmt.Errorf("roundrobin: %w", err)
-		}
-
-		log.Debug().
-			Uint16("id", id).
-			Int("attempt", i+1).
-			Int("maxAttempts", maxAttempts).
-			Msgf(`forwarding request to "%s"`, upstream.String())
+// truncateDNSMessage drops the answer, authority and additional sections of
+// an already-packed message and sets the TC bit, signalling the client to
+// retry over TCP.
+func truncateDNSMessage(data []byte) ([]byte, error) {
+	msg := &dnsmessage.Message{}
 
-		dataReader := bytes.NewReader(data)
+	if err := msg.Unpack(data); err != nil {
+		return nil, fmt.Errorf("dnsmessage: %w", err)
+	}
 
-		req, err := http.NewRequest(http.MethodPost, upstream.String(), dataReader)
-		if err != nil {
-			return nil, fmt.Errorf("http: %w", err)
-		}
+	msg.Header.Truncated = true
+	msg.Answers = nil
+	msg.Authorities = nil
+	msg.Additionals = nil
 
-		req.Header.Add("content-type", "application/dns-message")
-		req.Header.Add("accept", "application/dns-message")
+	truncated, err := msg.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("dnsmessage: %w", err)
+	}
 
-		res, err := s.httpClient.Do(req)
-		if err != nil {
-			log.Err(fmt.Errorf("http: %w", err)).
-				Uint16("id", id).
-				Int("attempt", i+1).
-				Send()
+	return truncated, nil
+}
 
+// requestedUDPPayloadSize looks for an EDNS0 OPT record in msg's additional
+// section and returns the UDP payload size it advertises (RFC 6891 section
+// 6.2.3), clamped to maxUDPPayloadSize. Clients without EDNS0 get the
+// RFC 1035 default of 512 bytes.
+func requestedUDPPayloadSize(msg *dnsmessage.Message) int {
+	for _, additional := range msg.Additionals {
+		if additional.Header.Type != dnsmessage.TypeOPT {
 			continue
 		}
-		defer res.Body.Close()
-
-		if res.StatusCode != 200 {
-			log.Err(fmt.Errorf(`http: invalid status code "%d"`, res.StatusCode)).
-				Uint16("id", id).
-				Int("attempt", i+1).
-				Send()
 
-			continue
+		size := int(additional.Header.Class)
+		if size < defaultUDPPayloadSize {
+			return defaultUDPPayloadSize
 		}
 
-		resData, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return nil, fmt.Errorf("io: %w", err)
+		if size > maxUDPPayloadSize {
+			return maxUDPPayloadSize
 		}
 
-		return resData, nil
+		return size
+	}
+
+	return defaultUDPPayloadSize
+}
+
+func (s *Server) forwardDataToUpstream(ctx context.Context, id uint16, upstreamRoundRobin *roundrobin.RoundRobin, data []byte) ([]byte, *url.URL, error) {
+	exchange := func(ctx context.Context, upstream *url.URL, data []byte) ([]byte, error) {
+		return s.exchangeUpstream(ctx, id, upstream, data)
+	}
+
+	resData, upstream, err := s.upstreamStrategy.Exchange(ctx, upstreamRoundRobin, exchange, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("strategy: %w", err)
+	}
+
+	return resData, upstream, nil
+}
+
+// exchangeUpstream looks up (or lazily creates) the pooled upstream.Upstream
+// for upstreamURL and exchanges data with it, dispatching to a DoH, DoT,
+// plain TCP or plain UDP transport depending on upstreamURL's scheme.
+func (s *Server) exchangeUpstream(ctx context.Context, id uint16, upstreamURL *url.URL, data []byte) ([]byte, error) {
+	log.Debug().
+		Uint16("id", id).
+		Msgf(`forwarding request to "%s"`, upstreamURL.String())
+
+	up, err := s.upstreamPool.Get(upstreamURL)
+	if err != nil {
+		return nil, fmt.Errorf("upstream: %w", err)
+	}
+
+	resData, err := up.Exchange(ctx, data)
+	if err != nil {
+		return nil, fmt.Errorf("upstream: %w", err)
 	}
 
-	return nil, errors.New("max attempts reached")
+	return resData, nil
 }
 
 func (s *Server) answerQuestionWithBlocklist(id uint16, question *dnsmessage.Question) *dnsmessage.Message {
 	name := question.Name.Data[:question.Name.Length-1]
 
-	blocklisted, ok := s.blocklist[string(name)]
-	if !ok || !blocklisted {
+	blocklisted := s.blocklist[string(name)]
+
+	if !blocklisted && s.lists != nil {
+		blocklisted = s.lists.Contains(string(name))
+	}
+
+	if !blocklisted {
 		return nil
 	}
 
@@ -394,18 +745,152 @@ func parseUDPAddress(address string) (*net.UDPAddr, error) {
 	}, nil
 }
 
+func parseTCPAddress(address string) (*net.TCPAddr, error) {
+	host, stringPort, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("net: %w", err)
+	}
+
+	port, err := strconv.Atoi(stringPort)
+	if err != nil {
+		return nil, fmt.Errorf("strconv: %w", err)
+	}
+
+	parsedIP := net.ParseIP(host)
+	if parsedIP == nil {
+		return nil, fmt.Errorf(`"%s" is not a valid ip address`, host)
+	}
+
+	return &net.TCPAddr{
+		IP:   parsedIP,
+		Port: port,
+	}, nil
+}
+
+// buildRoutes parses each route's upstream URLs into its own round-robin
+// pool and sorts the result by descending label count, so the most
+// specific suffix is always matched first by pickUpstreamRoundRobin.
+func buildRoutes(routes map[string][]string) ([]*route, error) {
+	result := make([]*route, 0, len(routes))
+
+	for suffix, upstreams := range routes {
+		upstreamURLs := make([]*url.URL, 0, len(upstreams))
+
+		for _, upstream := range upstreams {
+			upstreamURL, err := url.Parse(upstream)
+			if err != nil {
+				return nil, fmt.Errorf("url: %w", err)
+			}
+
+			upstreamURLs = append(upstreamURLs, upstreamURL)
+		}
+
+		result = append(result, &route{
+			suffix:     strings.ToLower(suffix),
+			roundRobin: roundrobin.New(upstreamURLs),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return strings.Count(result[i].suffix, ".") > strings.Count(result[j].suffix, ".")
+	})
+
+	return result, nil
+}
+
+// QueryLogConfig configures the optional structured query log subsystem;
+// the zero value disables it entirely.
+type QueryLogConfig struct {
+	JSONLPath  string
+	SQLitePath string
+	APIAddress string
+}
+
+// buildQueryLog wires up a querylog.Logger from the configured sinks. It
+// returns a nil Logger when no sink is configured, which disables query
+// logging for the whole server.
+func buildQueryLog(cfg QueryLogConfig) (*querylog.Logger, *querylog.SQLiteSink, error) {
+	sinks := make([]querylog.Sink, 0, 2)
+
+	var sqliteSink *querylog.SQLiteSink
+
+	if cfg.JSONLPath != "" {
+		sink, err := querylog.NewJSONLSink(cfg.JSONLPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		sinks = append(sinks, sink)
+	}
+
+	if cfg.SQLitePath != "" {
+		sink, err := querylog.NewSQLiteSink(cfg.SQLitePath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		sinks = append(sinks, sink)
+		sqliteSink = sink
+	}
+
+	if len(sinks) == 0 {
+		if cfg.APIAddress != "" {
+			return nil, nil, errors.New("querylog: querylog-api-address requires querylog-jsonl or querylog-sqlite to be set")
+		}
+
+		return nil, nil, nil
+	}
+
+	return querylog.New(sinks...), sqliteSink, nil
+}
+
+// BlocklistConfig configures the optional remote/file blocklist ingestion
+// subsystem; the zero value disables it entirely.
+type BlocklistConfig struct {
+	Sources         []string
+	RefreshInterval time.Duration
+	CacheDir        string
+}
+
+// buildBlocklistManager starts a lists.Manager for cfg, or returns a nil
+// Manager when no sources are configured, which leaves blocklisting to the
+// inline blocklist/blockregex checks.
+func buildBlocklistManager(cfg BlocklistConfig) (*lists.Manager, error) {
+	if len(cfg.Sources) == 0 {
+		return nil, nil
+	}
+
+	manager := lists.New(cfg.Sources, cfg.RefreshInterval, cfg.CacheDir)
+
+	if err := manager.Start(); err != nil {
+		return nil, fmt.Errorf("lists: %w", err)
+	}
+
+	return manager, nil
+}
+
 func New(
 	address string,
 	upstreams []string,
+	upstreamStrategy strategy.Name,
+	routes map[string][]string,
 	blocklist []string,
 	blockregex []string,
 	resolve map[string]string,
+	cacheSize int,
+	queryLogConfig QueryLogConfig,
+	blocklistConfig BlocklistConfig,
 ) (*Server, error) {
 	udpAddress, err := parseUDPAddress(address)
 	if err != nil {
 		return nil, err
 	}
 
+	tcpAddress, err := parseTCPAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
 	upstreamURLs := make([]*url.URL, 0, len(upstreams))
 
 	for _, upstream := range upstreams {
@@ -419,6 +904,11 @@ func New(
 
 	upstreamRoundRobin := roundrobin.New(upstreamURLs)
 
+	parsedRoutes, err := buildRoutes(routes)
+	if err != nil {
+		return nil, err
+	}
+
 	blocklistMap := make(map[string]bool, len(blocklist))
 	for _, blocklistItem := range blocklist {
 		blocklistMap[blocklistItem] = true
@@ -435,14 +925,35 @@ func New(
 		compiledBlockregex = append(compiledBlockregex, compiledRegex)
 	}
 
+	queryLog, querySQLiteSink, err := buildQueryLog(queryLogConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	blocklistManager, err := buildBlocklistManager(blocklistConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	strat, err := strategy.New(upstreamStrategy)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Server{
 		udpAddress:         udpAddress,
+		tcpAddress:         tcpAddress,
 		upstreamRoundRobin: upstreamRoundRobin,
+		upstreamStrategy:   strat,
+		routes:             parsedRoutes,
 		blocklist:          blocklistMap,
 		resolve:            resolve,
 		blockRegex:         compiledBlockregex,
-		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+		cache:              cache.New(cacheSize),
+		queryLog:           queryLog,
+		querySQLiteSink:    querySQLiteSink,
+		apiAddress:         queryLogConfig.APIAddress,
+		lists:              blocklistManager,
+		upstreamPool:       upstream.NewPool(),
 	}, nil
 }
@@ -0,0 +1,251 @@
+// Package strategy implements pluggable upstream-selection strategies for
+// forwarding a DNS query to one or more upstream resolvers.
+package strategy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adnsio/dotd/pkg/roundrobin"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Name identifies one of the pluggable upstream-selection strategies.
+type Name string
+
+const (
+	// RoundRobin forwards to upstreams one at a time, in round-robin
+	// order, retrying the next one on failure. This is dotd's original
+	// behavior.
+	RoundRobin Name = "round-robin"
+	// Parallel fans a query out to every upstream in the pool at once
+	// and returns the first successful response, cancelling the rest.
+	Parallel Name = "parallel"
+	// Fastest tracks each upstream's latency with an EWMA and always
+	// tries the lowest-latency upstream first, falling back to the next
+	// fastest on failure.
+	Fastest Name = "fastest"
+)
+
+// ewmaAlpha weighs how much a single exchange moves an upstream's tracked
+// latency; higher reacts faster to change, lower smooths out noise.
+const ewmaAlpha = 0.3
+
+// Exchanger sends a wire-format DNS message to a single upstream and
+// returns the wire-format response.
+type Exchanger func(ctx context.Context, upstream *url.URL, data []byte) ([]byte, error)
+
+// Strategy picks which upstream(s) in rr to query for a given request, and
+// reports back which one actually answered.
+type Strategy interface {
+	Exchange(ctx context.Context, rr *roundrobin.RoundRobin, exchange Exchanger, data []byte) ([]byte, *url.URL, error)
+}
+
+// New returns the Strategy identified by name, defaulting to RoundRobin for
+// an empty name. It returns an error for any non-empty name that isn't one
+// of RoundRobin, Parallel or Fastest, so a typo'd --upstream-strategy flag
+// fails loudly instead of silently falling back to RoundRobin.
+func New(name Name) (Strategy, error) {
+	switch name {
+	case "", RoundRobin:
+		return &roundRobinStrategy{}, nil
+	case Parallel:
+		return &parallelStrategy{}, nil
+	case Fastest:
+		return newFastestStrategy(), nil
+	default:
+		return nil, fmt.Errorf(`strategy: unknown strategy "%s"`, name)
+	}
+}
+
+// upstreamsOf drains rr once into a plain slice, deduplication isn't needed
+// since a round-robin pool never repeats an upstream within one lap.
+func upstreamsOf(rr *roundrobin.RoundRobin) []*url.URL {
+	length := rr.Length()
+	upstreams := make([]*url.URL, 0, length)
+
+	for i := 0; i < length; i++ {
+		upstream, err := rr.Pick()
+		if err != nil {
+			break
+		}
+
+		upstreams = append(upstreams, upstream)
+	}
+
+	return upstreams
+}
+
+// isAcceptableResponse reports whether data is a well-formed DNS response
+// that a client should be given, per the NOERROR/NXDOMAIN rule used to
+// decide a "successful" race in Parallel and Fastest.
+func isAcceptableResponse(data []byte) bool {
+	msg := &dnsmessage.Message{}
+
+	if err := msg.Unpack(data); err != nil {
+		return false
+	}
+
+	return msg.RCode == dnsmessage.RCodeSuccess || msg.RCode == dnsmessage.RCodeNameError
+}
+
+type roundRobinStrategy struct{}
+
+func (*roundRobinStrategy) Exchange(ctx context.Context, rr *roundrobin.RoundRobin, exchange Exchanger, data []byte) ([]byte, *url.URL, error) {
+	maxAttempts := rr.Length()
+
+	var lastErr error
+
+	for i := 0; i < maxAttempts; i++ {
+		upstream, err := rr.Pick()
+		if err != nil {
+			return nil, nil, fmt.Errorf("roundrobin: %w", err)
+		}
+
+		resData, err := exchange(ctx, upstream, data)
+		if err != nil {
+			lastErr = err
+
+			continue
+		}
+
+		return resData, upstream, nil
+	}
+
+	if lastErr != nil {
+		return nil, nil, lastErr
+	}
+
+	return nil, nil, errors.New("max attempts reached")
+}
+
+type parallelResult struct {
+	upstream *url.URL
+	data     []byte
+	err      error
+}
+
+type parallelStrategy struct{}
+
+func (*parallelStrategy) Exchange(ctx context.Context, rr *roundrobin.RoundRobin, exchange Exchanger, data []byte) ([]byte, *url.URL, error) {
+	upstreams := upstreamsOf(rr)
+	if len(upstreams) == 0 {
+		return nil, nil, errors.New("max attempts reached")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan parallelResult, len(upstreams))
+
+	for _, upstream := range upstreams {
+		upstream := upstream
+
+		go func() {
+			resData, err := exchange(ctx, upstream, data)
+			if err == nil && !isAcceptableResponse(resData) {
+				err = fmt.Errorf(`"%s" returned an unacceptable response`, upstream.String())
+			}
+
+			results <- parallelResult{upstream: upstream, data: resData, err: err}
+		}()
+	}
+
+	var lastErr error
+
+	for range upstreams {
+		res := <-results
+		if res.err != nil {
+			lastErr = res.err
+
+			continue
+		}
+
+		return res.data, res.upstream, nil
+	}
+
+	if lastErr != nil {
+		return nil, nil, lastErr
+	}
+
+	return nil, nil, errors.New("max attempts reached")
+}
+
+type fastestStrategy struct {
+	mu      sync.Mutex
+	latency map[string]time.Duration
+}
+
+func newFastestStrategy() *fastestStrategy {
+	return &fastestStrategy{
+		latency: make(map[string]time.Duration),
+	}
+}
+
+func (s *fastestStrategy) Exchange(ctx context.Context, rr *roundrobin.RoundRobin, exchange Exchanger, data []byte) ([]byte, *url.URL, error) {
+	upstreams := upstreamsOf(rr)
+	if len(upstreams) == 0 {
+		return nil, nil, errors.New("max attempts reached")
+	}
+
+	s.mu.Lock()
+	sort.Slice(upstreams, func(i, j int) bool {
+		return s.latency[upstreams[i].String()] < s.latency[upstreams[j].String()]
+	})
+	s.mu.Unlock()
+
+	var lastErr error
+
+	for _, upstream := range upstreams {
+		start := time.Now()
+
+		resData, err := exchange(ctx, upstream, data)
+		if err != nil {
+			lastErr = err
+
+			continue
+		}
+
+		if !isAcceptableResponse(resData) {
+			lastErr = fmt.Errorf(`"%s" returned an unacceptable response`, upstream.String())
+
+			continue
+		}
+
+		// only a successful exchange's latency is informative: an
+		// upstream that fails fast (connection refused, instant
+		// SERVFAIL) would otherwise record a near-zero latency and get
+		// sorted to the front next time, where it keeps failing first.
+		s.recordLatency(upstream, time.Since(start))
+
+		return resData, upstream, nil
+	}
+
+	if lastErr != nil {
+		return nil, nil, lastErr
+	}
+
+	return nil, nil, errors.New("max attempts reached")
+}
+
+// recordLatency folds d into upstream's tracked EWMA latency.
+func (s *fastestStrategy) recordLatency(upstream *url.URL, d time.Duration) {
+	key := upstream.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.latency[key]
+	if !ok {
+		s.latency[key] = d
+
+		return
+	}
+
+	s.latency[key] = time.Duration(ewmaAlpha*float64(d) + (1-ewmaAlpha)*float64(current))
+}
@@ -0,0 +1,56 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// dohUpstream speaks DNS over HTTPS per RFC 8484.
+type dohUpstream struct {
+	url        *url.URL
+	httpClient *http.Client
+}
+
+func newDoHUpstream(u *url.URL) *dohUpstream {
+	return &dohUpstream{
+		url: u,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext: bootstrapDialer.DialContext,
+			},
+		},
+	}
+}
+
+func (d *dohUpstream) Exchange(ctx context.Context, data []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("http: %w", err)
+	}
+
+	req.Header.Add("content-type", "application/dns-message")
+	req.Header.Add("accept", "application/dns-message")
+
+	res, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(`http: invalid status code "%d"`, res.StatusCode)
+	}
+
+	resData, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("io: %w", err)
+	}
+
+	return resData, nil
+}
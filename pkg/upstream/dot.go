@@ -0,0 +1,123 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// dotSessionCacheCapacity bounds how many TLS session tickets a DoT
+// upstream remembers for resumption, and doubles as the cap on how many
+// idle connections it keeps pooled.
+const dotSessionCacheCapacity = 32
+
+// dotUpstream speaks DNS over TLS per RFC 7858, pooling connections and
+// reusing TLS session tickets across exchanges so most queries resume an
+// existing session instead of paying for a fresh handshake.
+type dotUpstream struct {
+	addr      string
+	tlsConfig *tls.Config
+
+	mu    sync.Mutex
+	conns []*tls.Conn
+}
+
+func newDoTUpstream(u *url.URL) *dotUpstream {
+	return &dotUpstream{
+		addr: hostPort(u, "853"),
+		tlsConfig: &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			ServerName:         u.Hostname(),
+			ClientSessionCache: tls.NewLRUClientSessionCache(dotSessionCacheCapacity),
+		},
+	}
+}
+
+func (d *dotUpstream) Exchange(ctx context.Context, data []byte) ([]byte, error) {
+	conn, pooled, err := d.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+
+	resData, err := exchangeStream(ctx, conn, data)
+	if err != nil {
+		conn.Close()
+
+		if !pooled {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+
+		// conn came from the pool and may have been closed by the
+		// server's idle timeout between queries; retry once against a
+		// freshly-dialed connection before giving up.
+		conn, err = d.dial(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+
+		resData, err = exchangeStream(ctx, conn, data)
+		if err != nil {
+			conn.Close()
+
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+	}
+
+	d.release(conn)
+
+	return resData, nil
+}
+
+// acquire returns a pooled connection if one's idle, otherwise dials and
+// handshakes a new one. pooled reports which case happened, so Exchange
+// knows whether a failure is worth retrying against a fresh connection.
+func (d *dotUpstream) acquire(ctx context.Context) (conn *tls.Conn, pooled bool, err error) {
+	d.mu.Lock()
+	if n := len(d.conns); n > 0 {
+		conn := d.conns[n-1]
+		d.conns = d.conns[:n-1]
+		d.mu.Unlock()
+
+		return conn, true, nil
+	}
+	d.mu.Unlock()
+
+	conn, err = d.dial(ctx)
+
+	return conn, false, err
+}
+
+// dial opens and handshakes a brand-new connection to the upstream.
+func (d *dotUpstream) dial(ctx context.Context) (*tls.Conn, error) {
+	rawConn, err := bootstrapDialer.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := tls.Client(rawConn, d.tlsConfig)
+
+	if err := conn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// release returns conn to the pool for reuse, closing it instead if the
+// pool is already at capacity.
+func (d *dotUpstream) release(conn *tls.Conn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.conns) >= dotSessionCacheCapacity {
+		conn.Close()
+
+		return
+	}
+
+	d.conns = append(d.conns, conn)
+}
@@ -0,0 +1,46 @@
+package upstream
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// exchangeStream sends data on conn using the 2-byte length prefix RFC
+// 1035 section 4.2.2 specifies for DNS-over-TCP, and reads back a single
+// length-prefixed response. DoT (RFC 7858) reuses this same framing over
+// TLS. conn is given a deadline derived from ctx and closed immediately
+// if ctx is done before the exchange completes.
+func exchangeStream(ctx context.Context, conn net.Conn, data []byte) ([]byte, error) {
+	if err := conn.SetDeadline(deadlineFor(ctx)); err != nil {
+		return nil, fmt.Errorf("io: %w", err)
+	}
+
+	stop := watchContext(ctx, conn)
+	defer stop()
+
+	lengthPrefix := make([]byte, 2)
+	binary.BigEndian.PutUint16(lengthPrefix, uint16(len(data)))
+
+	if _, err := conn.Write(lengthPrefix); err != nil {
+		return nil, fmt.Errorf("io: %w", err)
+	}
+
+	if _, err := conn.Write(data); err != nil {
+		return nil, fmt.Errorf("io: %w", err)
+	}
+
+	if _, err := io.ReadFull(conn, lengthPrefix); err != nil {
+		return nil, fmt.Errorf("io: %w", err)
+	}
+
+	resData := make([]byte, binary.BigEndian.Uint16(lengthPrefix))
+
+	if _, err := io.ReadFull(conn, resData); err != nil {
+		return nil, fmt.Errorf("io: %w", err)
+	}
+
+	return resData, nil
+}
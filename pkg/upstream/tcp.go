@@ -0,0 +1,31 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// tcpUpstream speaks plain (unencrypted) DNS over TCP.
+type tcpUpstream struct {
+	addr string
+}
+
+func newTCPUpstream(u *url.URL) *tcpUpstream {
+	return &tcpUpstream{addr: hostPort(u, "53")}
+}
+
+func (t *tcpUpstream) Exchange(ctx context.Context, data []byte) ([]byte, error) {
+	conn, err := bootstrapDialer.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, fmt.Errorf("tcp: %w", err)
+	}
+	defer conn.Close()
+
+	resData, err := exchangeStream(ctx, conn, data)
+	if err != nil {
+		return nil, fmt.Errorf("tcp: %w", err)
+	}
+
+	return resData, nil
+}
@@ -0,0 +1,49 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// udpMaxMessageSize is the largest response read back from a plain UDP
+// upstream, matching the largest EDNS0 buffer size dotd's own listener
+// accepts from a client.
+const udpMaxMessageSize = 4096
+
+// udpUpstream speaks plain (unencrypted) DNS over UDP.
+type udpUpstream struct {
+	addr string
+}
+
+func newUDPUpstream(u *url.URL) *udpUpstream {
+	return &udpUpstream{addr: hostPort(u, "53")}
+}
+
+func (u *udpUpstream) Exchange(ctx context.Context, data []byte) ([]byte, error) {
+	conn, err := bootstrapDialer.DialContext(ctx, "udp", u.addr)
+	if err != nil {
+		return nil, fmt.Errorf("udp: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(deadlineFor(ctx)); err != nil {
+		return nil, fmt.Errorf("udp: %w", err)
+	}
+
+	stop := watchContext(ctx, conn)
+	defer stop()
+
+	if _, err := conn.Write(data); err != nil {
+		return nil, fmt.Errorf("udp: %w", err)
+	}
+
+	buf := make([]byte, udpMaxMessageSize)
+
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("udp: %w", err)
+	}
+
+	return buf[:n], nil
+}
@@ -0,0 +1,141 @@
+// Package upstream implements dotd's pluggable upstream transports —
+// DoH (https://), DoT (tls://), plain TCP (tcp://) and plain UDP
+// (udp://) — behind a single Exchange interface, so the rest of dotd
+// doesn't need to know which wire protocol a given upstream speaks.
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Upstream exchanges a single wire-format DNS message with one upstream
+// resolver and returns the wire-format response.
+type Upstream interface {
+	Exchange(ctx context.Context, data []byte) ([]byte, error)
+}
+
+// bootstrapResolver resolves the hostnames of DoT/DoH/TCP/UDP upstreams.
+// It's a single, shared zero-value net.Resolver, so lookups follow the
+// host's own resolver configuration; it has no bootstrap servers of its
+// own, and when the host resolves through this dotd server, resolving an
+// upstream's hostname depends on that same server.
+//
+//nolint:gochecknoglobals
+var bootstrapResolver = &net.Resolver{}
+
+// bootstrapDialer opens every Upstream's underlying connection, routing
+// hostname lookups through bootstrapResolver.
+//
+//nolint:gochecknoglobals
+var bootstrapDialer = &net.Dialer{
+	Timeout:  10 * time.Second,
+	Resolver: bootstrapResolver,
+}
+
+// defaultExchangeTimeout bounds how long a stream/packet transport waits
+// on a single exchange when ctx carries no deadline of its own.
+const defaultExchangeTimeout = 5 * time.Second
+
+// deadlineFor returns ctx's deadline if it has one, otherwise a deadline
+// defaultExchangeTimeout from now.
+func deadlineFor(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+
+	return time.Now().Add(defaultExchangeTimeout)
+}
+
+// watchContext closes conn as soon as ctx is done, so a Read/Write
+// blocked past cancellation (e.g. a losing racer in the parallel
+// strategy) wakes up immediately instead of waiting out the full
+// deadline. The returned func must be called once the exchange is over
+// to stop the watcher goroutine.
+func watchContext(ctx context.Context, conn net.Conn) func() {
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// Pool lazily builds and caches one Upstream per URL, so a DoT upstream's
+// connection pool and TLS session cache (and a DoH upstream's HTTP
+// keep-alives) are shared across exchanges instead of rebuilt per query.
+// It is safe for concurrent use.
+type Pool struct {
+	mu        sync.RWMutex
+	upstreams map[string]Upstream
+}
+
+// NewPool returns an empty Pool.
+func NewPool() *Pool {
+	return &Pool{
+		upstreams: make(map[string]Upstream),
+	}
+}
+
+// Get returns the Upstream for u, creating and caching it on first use.
+func (p *Pool) Get(u *url.URL) (Upstream, error) {
+	key := u.String()
+
+	p.mu.RLock()
+	existing, ok := p.upstreams[key]
+	p.mu.RUnlock()
+
+	if ok {
+		return existing, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if existing, ok := p.upstreams[key]; ok {
+		return existing, nil
+	}
+
+	created, err := newUpstream(u)
+	if err != nil {
+		return nil, err
+	}
+
+	p.upstreams[key] = created
+
+	return created, nil
+}
+
+// newUpstream builds the Upstream implementation matching u's scheme.
+func newUpstream(u *url.URL) (Upstream, error) {
+	switch u.Scheme {
+	case "https":
+		return newDoHUpstream(u), nil
+	case "tls":
+		return newDoTUpstream(u), nil
+	case "tcp":
+		return newTCPUpstream(u), nil
+	case "udp":
+		return newUDPUpstream(u), nil
+	default:
+		return nil, fmt.Errorf(`upstream: unsupported scheme "%s"`, u.Scheme)
+	}
+}
+
+// hostPort returns u's host, adding defaultPort if u didn't specify one.
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}